@@ -0,0 +1,256 @@
+// Package client is a reconnecting TeleChat WebSocket client. It wraps
+// gorilla/websocket so consumers don't have to re-implement framing, ping
+// handling, or the JSON envelope shape themselves.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"telechat/message"
+)
+
+// Message is the shared wire-protocol type also used by the server, so
+// handlers registered via Subscribe decode exactly what the server sent.
+type Message = message.Message
+
+// Options configures reconnect behavior. Zero values fall back to sane
+// defaults (see New).
+type Options struct {
+	Username string
+
+	// ReconnectInterval is the delay between a dropped connection and the
+	// next dial attempt once a connection had been established.
+	ReconnectInterval time.Duration
+	// RetryInterval is the initial backoff before the very first retry of a
+	// failed dial; it grows towards MaxBackoff on repeated failures.
+	RetryInterval time.Duration
+	// MaxBackoff caps the exponential backoff between dial attempts.
+	MaxBackoff time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Username == "" {
+		o.Username = "Anonymous"
+	}
+	if o.ReconnectInterval <= 0 {
+		o.ReconnectInterval = time.Second
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = time.Second
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 30 * time.Second
+	}
+	return o
+}
+
+// Client is a reconnecting WebSocket connection to a TeleChat server.
+type Client struct {
+	url  string
+	opts Options
+
+	mutex         sync.Mutex
+	conn          *websocket.Conn
+	subscriptions map[string]func(Message) error
+	lastSeq       map[string]uint64
+}
+
+// New returns a Client for the given WebSocket URL (e.g.
+// "ws://localhost:9090/ws?username=bot"). It doesn't dial until Run is
+// called.
+func New(url string, opts Options) *Client {
+	return &Client{
+		url:           url,
+		opts:          opts.withDefaults(),
+		subscriptions: make(map[string]func(Message) error),
+		lastSeq:       make(map[string]uint64),
+	}
+}
+
+// Subscribe registers handler for every message received on topic. If the
+// client is already connected the subscribe frame is sent immediately;
+// otherwise (and on every future reconnect) it's sent as soon as the
+// connection is established.
+func (c *Client) Subscribe(topic string, handler func(Message) error) error {
+	c.mutex.Lock()
+	c.subscriptions[topic] = handler
+	conn := c.conn
+	since := c.lastSeq[topic]
+	c.mutex.Unlock()
+
+	if conn == nil {
+		return nil
+	}
+	return sendSubscribe(conn, topic, since)
+}
+
+func sendSubscribe(conn *websocket.Conn, topic string, since uint64) error {
+	frame := map[string]interface{}{"type": "subscribe", "topic": topic}
+	if since > 0 {
+		frame["since"] = since
+	}
+	return conn.WriteJSON(frame)
+}
+
+// Publish sends content as a new message on topic.
+func (c *Client) Publish(topic, content string) error {
+	return c.sendFrame(map[string]interface{}{
+		"type":    "message",
+		"topic":   topic,
+		"content": content,
+	})
+}
+
+// Edit requests that message id be edited to content.
+func (c *Client) Edit(id, content string) error {
+	return c.sendFrame(map[string]interface{}{
+		"type":      "edit",
+		"messageId": id,
+		"content":   content,
+	})
+}
+
+// Delete requests that message id be deleted.
+func (c *Client) Delete(id string) error {
+	return c.sendFrame(map[string]interface{}{
+		"type":      "delete",
+		"messageId": id,
+	})
+}
+
+func (c *Client) sendFrame(frame map[string]interface{}) error {
+	c.mutex.Lock()
+	conn := c.conn
+	c.mutex.Unlock()
+	if conn == nil {
+		return fmt.Errorf("client: not connected")
+	}
+	return conn.WriteJSON(frame)
+}
+
+// LastSeq returns the highest sequence number observed on topic so far. A
+// reconnecting Subscribe uses it automatically, but callers persisting
+// their own cursor across process restarts can read it too.
+func (c *Client) LastSeq(topic string) uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.lastSeq[topic]
+}
+
+// Run dials the server and services the connection until ctx is canceled,
+// reconnecting with exponential backoff and re-subscribing to every topic
+// after each reconnect. It returns ctx.Err() once ctx is done.
+func (c *Client) Run(ctx context.Context) error {
+	backoff := c.opts.RetryInterval
+	for {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.url, nil)
+		if err != nil {
+			log.Printf("client: dial error: %v", err)
+			if !sleepCtx(ctx, backoff) {
+				return ctx.Err()
+			}
+			backoff = nextBackoff(backoff, c.opts.MaxBackoff)
+			continue
+		}
+		backoff = c.opts.RetryInterval
+
+		subs := c.resubscribeAll(conn)
+		err = c.readLoop(conn, subs)
+
+		c.mutex.Lock()
+		c.conn = nil
+		c.mutex.Unlock()
+		conn.Close()
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("client: connection lost: %v, reconnecting", err)
+		if !sleepCtx(ctx, c.opts.ReconnectInterval) {
+			return ctx.Err()
+		}
+	}
+}
+
+// resubscribeAll installs conn as the active connection and replays every
+// subscription (with its last-seen sequence number as the resume cursor) so
+// a reconnect doesn't lose messages published during the dead interval.
+func (c *Client) resubscribeAll(conn *websocket.Conn) map[string]func(Message) error {
+	c.mutex.Lock()
+	c.conn = conn
+	subs := make(map[string]func(Message) error, len(c.subscriptions))
+	since := make(map[string]uint64, len(c.lastSeq))
+	for topic, handler := range c.subscriptions {
+		subs[topic] = handler
+		since[topic] = c.lastSeq[topic]
+	}
+	c.mutex.Unlock()
+
+	for topic := range subs {
+		if err := sendSubscribe(conn, topic, since[topic]); err != nil {
+			log.Printf("client: resubscribe to %s failed: %v", topic, err)
+		}
+	}
+	return subs
+}
+
+// readLoop drains conn until it errors. gorilla/websocket answers ping
+// control frames with pong automatically, so the 54s ping cadence the
+// server runs (see Client.writePump server-side) needs no handling here.
+func (c *Client) readLoop(conn *websocket.Conn, subs map[string]func(Message) error) error {
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		var msg Message
+		if err := json.Unmarshal(payload, &msg); err != nil || msg.Type != "message" {
+			continue // not a chat Message frame (e.g. userList, messageEdited), ignore
+		}
+
+		c.mutex.Lock()
+		if msg.Seq > c.lastSeq[msg.Topic] {
+			c.lastSeq[msg.Topic] = msg.Seq
+		}
+		c.mutex.Unlock()
+
+		handler, ok := subs[msg.Topic]
+		if !ok {
+			continue
+		}
+		if err := handler(msg); err != nil {
+			log.Printf("client: handler for %s returned error: %v", msg.Topic, err)
+		}
+	}
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func nextBackoff(current, max time.Duration) time.Duration {
+	next := current * 2
+	if next > max {
+		next = max
+	}
+	jitter := time.Duration(rand.Int63n(int64(next)/4 + 1))
+	return next - jitter/2
+}
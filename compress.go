@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Codec tags lead every compressed history batch frame (see
+// Hub.sendRecentMessages) so the receiver knows which decoder to use.
+const (
+	codecGzip    byte = 1
+	codecDeflate byte = 2
+	codecBrotli  byte = 3
+)
+
+// compressBatch marshals messages as a single JSON array and compresses it
+// with codec ("gzip", "deflate", or "br"), returning one frame with a
+// leading 1-byte codec tag followed by the compressed payload.
+func compressBatch(codec string, messages []Message) ([]byte, error) {
+	payload, err := json.Marshal(messages)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	var tag byte
+
+	switch codec {
+	case "gzip":
+		tag = codecGzip
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case "deflate":
+		tag = codecDeflate
+		w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	case "br":
+		tag = codecBrotli
+		w := brotli.NewWriter(&buf)
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression codec %q", codec)
+	}
+
+	frame := make([]byte, 0, buf.Len()+1)
+	frame = append(frame, tag)
+	frame = append(frame, buf.Bytes()...)
+	return frame, nil
+}
+
+// validCompressCodec reports whether codec is one compressBatch supports.
+func validCompressCodec(codec string) bool {
+	switch codec {
+	case "gzip", "deflate", "br":
+		return true
+	default:
+		return false
+	}
+}
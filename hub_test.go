@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func populateBenchHub(h *Hub, topic string, n int) {
+	for i := 0; i < n; i++ {
+		h.addMessage(Message{
+			ID:        fmt.Sprintf("msg-%d", i),
+			Type:      "message",
+			Topic:     topic,
+			Username:  "bench",
+			UserID:    "bench-user",
+			Content:   "benchmark payload for compression comparison, long enough to compress well",
+			Timestamp: time.Now(),
+		})
+	}
+}
+
+func benchmarkReplay(b *testing.B, codec string) {
+	h := newHub()
+	populateBenchHub(h, "bench", 10000)
+	since := uint64(0)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client := &Client{
+			Username:        "bench-client",
+			Send:            make(chan outboundFrame, 20000),
+			CompressCodec:   codec,
+			MinCompressSize: defaultMinCompressSize,
+		}
+		h.sendRecentMessages(client, "bench", &since)
+	}
+}
+
+// BenchmarkSendRecentMessagesUncompressed replays 10k messages as individual
+// text frames, the historical behavior.
+func BenchmarkSendRecentMessagesUncompressed(b *testing.B) {
+	benchmarkReplay(b, "")
+}
+
+// The following justify compressing large history replays by default: each
+// sends the same 10k messages as a single compressed binary frame instead.
+func BenchmarkSendRecentMessagesGzip(b *testing.B)    { benchmarkReplay(b, "gzip") }
+func BenchmarkSendRecentMessagesDeflate(b *testing.B) { benchmarkReplay(b, "deflate") }
+func BenchmarkSendRecentMessagesBrotli(b *testing.B)  { benchmarkReplay(b, "br") }
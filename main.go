@@ -1,59 +1,186 @@
 package main
 
 import (
+	"compress/flate"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+
+	"telechat/message"
+	"telechat/proto"
 )
 
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow connections from any origin
 	},
+	EnableCompression: true, // negotiate permessage-deflate with clients that support it
 }
 
+// wsCompressionLevel controls the flate compression level used for
+// permessage-deflate once negotiated. Accepts compress/flate's range,
+// -1 (DefaultCompression) through 9 (BestCompression).
+var wsCompressionLevel = flag.Int("ws-compression-level", flate.DefaultCompression, "permessage-deflate compression level (-1 to 9)")
+
 type Client struct {
-	ID       string          `json:"id"`
-	Username string          `json:"username"`
-	Conn     *websocket.Conn `json:"-"`
-	Send     chan []byte     `json:"-"`
-	IsTyping bool            `json:"isTyping"`
-	LastSeen time.Time       `json:"lastSeen"`
+	ID              string             `json:"id"`
+	Username        string             `json:"username"`
+	Conn            *websocket.Conn    `json:"-"`
+	Send            chan outboundFrame `json:"-"`
+	IsTyping        bool               `json:"isTyping"`
+	LastSeen        time.Time          `json:"lastSeen"`
+	DefaultSince    *uint64            `json:"-"` // resume cursor from the ?since= handshake param, used when a subscribe omits its own
+	CompressCodec   string             `json:"-"` // ?compress= handshake param: "gzip", "deflate", or "br"
+	MinCompressSize int                `json:"-"` // copied from Hub.MinCompressSize at connect time
+}
+
+// outboundFrame is what rides Client.Send; writePump writes it as a text or
+// binary WebSocket frame depending on Binary.
+type outboundFrame struct {
+	Binary  bool
+	Payload []byte
+}
+
+// Message is an alias for the shared wire type so the client package (see
+// client/client.go) can decode server broadcasts without duplicating it.
+type Message = message.Message
+
+// Topic is a named channel clients can subscribe to. Messages published to a
+// topic get a monotonically increasing sequence number scoped to that topic.
+type Topic struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	seq       uint64
+}
+
+type subscription struct {
+	client *Client
+	topic  string
+	since  *uint64 // resume cursor: replay messages with Seq > *since instead of the last 50
+}
+
+// subscriber is anything Hub.run's broadcast case can deliver a payload to.
+// *Client (WebSocket) and *chanSubscriber (plain HTTP streams, see pubsub.go)
+// both implement it so broadcast fan-out doesn't need to know the transport.
+type subscriber interface {
+	Deliver(payload []byte) error
+}
+
+// Deliver sends payload to the client's outgoing channel without blocking;
+// writePump drains it. A full buffer means the client is too slow and is
+// reported back to the caller so it can be dropped.
+func (c *Client) Deliver(payload []byte) error {
+	select {
+	case c.Send <- outboundFrame{Payload: payload}:
+		return nil
+	default:
+		return fmt.Errorf("client %s send buffer full", c.Username)
+	}
 }
 
-type Message struct {
-	ID        string    `json:"id"`
-	Type      string    `json:"type"`
-	Username  string    `json:"username"`
-	UserID    string    `json:"userId"`
-	Content   string    `json:"content"`
-	Timestamp time.Time `json:"timestamp"`
-	Edited    bool      `json:"edited"`
+type topicMessage struct {
+	topic   string
+	payload []byte
 }
 
 type Hub struct {
-	clients    map[*Client]bool
-	broadcast  chan []byte
-	register   chan *Client
-	unregister chan *Client
-	messages   []Message
-	mutex      sync.RWMutex
+	topics       map[string]*Topic
+	subscribers  map[string]map[subscriber]bool // topic -> subscribers (WebSocket clients and HTTP streams)
+	clientTopics map[*Client]map[string]bool    // client -> topics it has joined
+	broadcast    chan topicMessage
+	register     chan *Client
+	unregister   chan *Client
+	subscribe    chan subscription
+	unsubscribe  chan subscription
+	messages     []Message
+	wal          *WAL
+	// MinCompressSize is the smallest payload, in bytes, worth compressing.
+	// Below it the per-frame CPU cost isn't worth it (e.g. an 80-byte typing
+	// indicator), so new clients are handed this threshold uncompressed.
+	MinCompressSize int
+	mutex           sync.RWMutex
 }
 
+const defaultMinCompressSize = 256
+
 func newHub() *Hub {
 	return &Hub{
-		broadcast:  make(chan []byte),
-		register:   make(chan *Client),
-		unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
-		messages:   make([]Message, 0),
+		topics:          make(map[string]*Topic),
+		subscribers:     make(map[string]map[subscriber]bool),
+		clientTopics:    make(map[*Client]map[string]bool),
+		broadcast:       make(chan topicMessage),
+		register:        make(chan *Client),
+		unregister:      make(chan *Client),
+		subscribe:       make(chan subscription),
+		unsubscribe:     make(chan subscription),
+		messages:        make([]Message, 0),
+		MinCompressSize: defaultMinCompressSize,
+	}
+}
+
+// loadWAL opens the write-ahead log at path and replays it into h.messages,
+// restoring per-topic sequence counters. It must be called before hub.run
+// starts processing traffic.
+func (h *Hub) loadWAL(path string, maxBytes int64, maxAge time.Duration) error {
+	wal, err := openWAL(path, maxBytes, maxAge)
+	if err != nil {
+		return err
+	}
+
+	var loaded int
+	err = wal.replay(func(rec walRecord) {
+		switch rec.Op {
+		case walOpAdd:
+			h.messages = append(h.messages, rec.Message)
+			topic := h.getOrCreateTopic(rec.Message.Topic)
+			if rec.Message.Seq > topic.seq {
+				topic.seq = rec.Message.Seq
+			}
+			loaded++
+		case walOpEdit:
+			for i, msg := range h.messages {
+				if msg.ID == rec.MessageID && msg.UserID == rec.UserID {
+					h.messages[i].Content = rec.Content
+					h.messages[i].Edited = true
+					break
+				}
+			}
+		case walOpDelete:
+			for i, msg := range h.messages {
+				if msg.ID == rec.MessageID && msg.UserID == rec.UserID {
+					h.messages = append(h.messages[:i], h.messages[i+1:]...)
+					break
+				}
+			}
+		}
+	})
+	if err != nil {
+		return err
 	}
+
+	h.wal = wal
+	log.Printf("📼 Replayed %d messages from WAL at %s", loaded, path)
+	return nil
+}
+
+// getOrCreateTopic returns the Topic for name, creating it if this is the
+// first time it's been seen. Callers must hold h.mutex.
+func (h *Hub) getOrCreateTopic(name string) *Topic {
+	topic, ok := h.topics[name]
+	if !ok {
+		topic = &Topic{Name: name, CreatedAt: time.Now()}
+		h.topics[name] = topic
+		log.Printf("🆕 Topic created: %s", name)
+	}
+	return topic
 }
 
 func (h *Hub) run() {
@@ -62,101 +189,155 @@ func (h *Hub) run() {
 		select {
 		case client := <-h.register:
 			h.mutex.Lock()
-			h.clients[client] = true
-			clientCount := len(h.clients)
+			h.clientTopics[client] = make(map[string]bool)
 			h.mutex.Unlock()
 
-			log.Printf("➕ Client %s (%s) connected. Total clients: %d", client.Username, client.ID, clientCount)
+			log.Printf("➕ Client %s (%s) connected", client.Username, client.ID)
 
-			// Send recent messages to new client
-			h.sendRecentMessages(client)
+		case client := <-h.unregister:
+			h.mutex.Lock()
+			topics := h.clientTopics[client]
+			for topic := range topics {
+				if subs, ok := h.subscribers[topic]; ok {
+					delete(subs, client)
+				}
+			}
+			delete(h.clientTopics, client)
+			close(client.Send)
+			h.mutex.Unlock()
 
-			// Broadcast user joined - give a small delay to ensure connection is ready
-			go func() {
-				time.Sleep(100 * time.Millisecond)
-				log.Printf("🔄 Broadcasting user list after client %s joined", client.Username)
-				h.broadcastUserList()
-			}()
+			log.Printf("➖ Client %s (%s) disconnected", client.Username, client.ID)
 
-		case client := <-h.unregister:
+			for topic := range topics {
+				go h.broadcastUserList(topic)
+			}
+
+		case sub := <-h.subscribe:
 			h.mutex.Lock()
-			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.Send)
+			h.getOrCreateTopic(sub.topic)
+			if h.subscribers[sub.topic] == nil {
+				h.subscribers[sub.topic] = make(map[subscriber]bool)
+			}
+			h.subscribers[sub.topic][sub.client] = true
+			if h.clientTopics[sub.client] == nil {
+				h.clientTopics[sub.client] = make(map[string]bool)
 			}
-			clientCount := len(h.clients)
+			h.clientTopics[sub.client][sub.topic] = true
 			h.mutex.Unlock()
 
-			log.Printf("➖ Client %s (%s) disconnected. Total clients: %d", client.Username, client.ID, clientCount)
+			log.Printf("📌 %s subscribed to topic %s", sub.client.Username, sub.topic)
+			h.sendRecentMessages(sub.client, sub.topic, sub.since)
+
+			go func(topic string) {
+				time.Sleep(100 * time.Millisecond)
+				h.broadcastUserList(topic)
+			}(sub.topic)
+
+		case sub := <-h.unsubscribe:
+			h.mutex.Lock()
+			if subs, ok := h.subscribers[sub.topic]; ok {
+				delete(subs, sub.client)
+			}
+			if topics, ok := h.clientTopics[sub.client]; ok {
+				delete(topics, sub.topic)
+			}
+			h.mutex.Unlock()
 
-			// Broadcast user left
-			h.broadcastUserList()
+			log.Printf("📍 %s unsubscribed from topic %s", sub.client.Username, sub.topic)
+			go h.broadcastUserList(sub.topic)
 
-		case message := <-h.broadcast:
+		case msg := <-h.broadcast:
 			h.mutex.RLock()
-			clientCount := len(h.clients)
-			log.Printf("📢 Broadcasting message to %d clients: %s", clientCount, string(message))
+			subs := h.subscribers[msg.topic]
+			subCount := len(subs)
+			log.Printf("📢 Broadcasting to %d subscribers of %s: %s", subCount, msg.topic, string(msg.payload))
 
 			successCount := 0
-			failedClients := make([]*Client, 0)
-
-			for client := range h.clients {
-				select {
-				case client.Send <- message:
-					successCount++
-					log.Printf("✅ Sent message to %s", client.Username)
-				default:
-					log.Printf("❌ Failed to send to client %s, marking for removal", client.Username)
-					failedClients = append(failedClients, client)
+			failedSubs := make([]subscriber, 0)
+
+			for sub := range subs {
+				if err := sub.Deliver(msg.payload); err != nil {
+					log.Printf("❌ Failed to deliver to subscriber on %s, marking for removal: %v", msg.topic, err)
+					failedSubs = append(failedSubs, sub)
+					continue
 				}
+				successCount++
 			}
 			h.mutex.RUnlock()
 
-			// Clean up failed clients
-			if len(failedClients) > 0 {
+			if len(failedSubs) > 0 {
 				h.mutex.Lock()
-				for _, client := range failedClients {
-					if _, ok := h.clients[client]; ok {
-						close(client.Send)
-						delete(h.clients, client)
+				for _, sub := range failedSubs {
+					if subs, ok := h.subscribers[msg.topic]; ok {
+						delete(subs, sub)
 					}
 				}
 				h.mutex.Unlock()
-				log.Printf("🧹 Cleaned up %d failed clients", len(failedClients))
+				log.Printf("🧹 Cleaned up %d failed subscribers on topic %s", len(failedSubs), msg.topic)
 			}
 
-			log.Printf("✅ Message sent to %d/%d clients", successCount, clientCount)
+			log.Printf("✅ Message sent to %d/%d subscribers on topic %s", successCount, subCount, msg.topic)
 		}
 	}
 }
 
-func (h *Hub) sendRecentMessages(client *Client) {
+func (h *Hub) sendRecentMessages(client *Client, topic string, since *uint64) {
 	h.mutex.RLock()
-	recentMessages := h.messages
-	if len(recentMessages) > 50 {
+	var recentMessages []Message
+	for _, msg := range h.messages {
+		if msg.Topic != topic {
+			continue
+		}
+		if since != nil {
+			if msg.Seq > *since {
+				recentMessages = append(recentMessages, msg)
+			}
+			continue
+		}
+		recentMessages = append(recentMessages, msg)
+	}
+	if since == nil && len(recentMessages) > 50 {
 		recentMessages = recentMessages[len(recentMessages)-50:]
 	}
 	messageCount := len(recentMessages)
 	h.mutex.RUnlock()
 
-	log.Printf("📜 Sending %d recent messages to %s", messageCount, client.Username)
+	log.Printf("📜 Sending %d recent messages on %s to %s", messageCount, topic, client.Username)
+
+	if client.CompressCodec != "" && messageCount > 0 {
+		frame, err := compressBatch(client.CompressCodec, recentMessages)
+		if err != nil {
+			log.Printf("❌ Failed to compress history batch for %s: %v", client.Username, err)
+			return
+		}
+		select {
+		case client.Send <- outboundFrame{Binary: true, Payload: frame}:
+		default:
+			log.Printf("❌ Failed to send compressed history to %s", client.Username)
+		}
+		return
+	}
 
 	for _, msg := range recentMessages {
 		msgBytes, _ := json.Marshal(msg)
 		select {
-		case client.Send <- msgBytes:
+		case client.Send <- outboundFrame{Payload: msgBytes}:
 		default:
 			log.Printf("❌ Failed to send recent message to %s", client.Username)
-			// Don't close or delete client here - let the writePump handle it
 			return
 		}
 	}
 }
 
-func (h *Hub) broadcastUserList() {
+func (h *Hub) broadcastUserList(topic string) {
 	h.mutex.RLock()
-	users := make([]Client, 0, len(h.clients))
-	for client := range h.clients {
+	subs := h.subscribers[topic]
+	users := make([]Client, 0, len(subs))
+	for sub := range subs {
+		client, ok := sub.(*Client)
+		if !ok {
+			continue // HTTP stream subscribers aren't users
+		}
 		users = append(users, Client{
 			ID:       client.ID,
 			Username: client.Username,
@@ -167,63 +348,125 @@ func (h *Hub) broadcastUserList() {
 	userCount := len(users)
 	h.mutex.RUnlock()
 
-	log.Printf("👥 Broadcasting user list: %d users", userCount)
-	for _, user := range users {
-		log.Printf("   - %s (typing: %v)", user.Username, user.IsTyping)
-	}
+	log.Printf("👥 Broadcasting user list for %s: %d users", topic, userCount)
 
 	userListMsg := map[string]interface{}{
 		"type":      "userList",
+		"topic":     topic,
 		"users":     users,
 		"count":     userCount,
 		"timestamp": time.Now(),
 	}
 
 	msgBytes, _ := json.Marshal(userListMsg)
-	h.broadcast <- msgBytes
+	h.broadcast <- topicMessage{topic: topic, payload: msgBytes}
 }
 
-func (h *Hub) addMessage(msg Message) {
+func (h *Hub) addMessage(msg Message) Message {
 	h.mutex.Lock()
+	topic := h.getOrCreateTopic(msg.Topic)
+	topic.seq++
+	msg.Seq = topic.seq
 	h.messages = append(h.messages, msg)
 	messageCount := len(h.messages)
 	h.mutex.Unlock()
-	log.Printf("💾 Message stored. Total messages: %d", messageCount)
+
+	if h.wal != nil {
+		if err := h.wal.append(walRecord{Op: walOpAdd, Message: msg}); err != nil {
+			log.Printf("❌ WAL append error: %v", err)
+		}
+	}
+	log.Printf("💾 Message stored on %s (seq %d). Total messages: %d", msg.Topic, msg.Seq, messageCount)
+	return msg
+}
+
+// findMessageLocked returns the index of messageID in h.messages, or -1 if
+// it doesn't exist. Callers must hold h.mutex.
+func (h *Hub) findMessageLocked(messageID string) int {
+	for i, msg := range h.messages {
+		if msg.ID == messageID {
+			return i
+		}
+	}
+	return -1
 }
 
-func (h *Hub) editMessage(messageID, userID, newContent string) bool {
+// editMessage edits messageID's content if userID owns it. The bool return
+// distinguishes "nothing to broadcast" from an error; the error distinguishes
+// not-found from not-authorized so the caller can report an actionable code.
+func (h *Hub) editMessage(messageID, userID, newContent string) (bool, error) {
 	h.mutex.Lock()
-	defer h.mutex.Unlock()
+	i := h.findMessageLocked(messageID)
+	if i == -1 {
+		h.mutex.Unlock()
+		log.Printf("❌ Message %s not found for editing by %s", messageID, userID)
+		return false, proto.NewUserError("not_found", fmt.Sprintf("message %s not found", messageID))
+	}
+	if h.messages[i].UserID != userID {
+		h.mutex.Unlock()
+		log.Printf("❌ Message %s not owned by %s, edit refused", messageID, userID)
+		return false, proto.NewUserError("forbidden", fmt.Sprintf("message %s is not yours to edit", messageID))
+	}
 
-	for i, msg := range h.messages {
-		if msg.ID == messageID && msg.UserID == userID {
-			log.Printf("✏️ Editing message %s by %s", messageID, userID)
-			h.messages[i].Content = newContent
-			h.messages[i].Edited = true
-			return true
+	log.Printf("✏️ Editing message %s by %s", messageID, userID)
+	h.messages[i].Content = newContent
+	h.messages[i].Edited = true
+	h.mutex.Unlock()
+
+	if h.wal != nil {
+		rec := walRecord{Op: walOpEdit, MessageID: messageID, UserID: userID, Content: newContent}
+		if err := h.wal.append(rec); err != nil {
+			log.Printf("❌ WAL append error: %v", err)
 		}
 	}
-	log.Printf("❌ Message %s not found for editing by %s", messageID, userID)
-	return false
+	return true, nil
 }
 
-func (h *Hub) deleteMessage(messageID, userID string) bool {
+// deleteMessage deletes messageID if userID owns it. See editMessage for the
+// return-value contract.
+func (h *Hub) deleteMessage(messageID, userID string) (bool, error) {
 	h.mutex.Lock()
-	defer h.mutex.Unlock()
+	i := h.findMessageLocked(messageID)
+	if i == -1 {
+		h.mutex.Unlock()
+		log.Printf("❌ Message %s not found for deletion by %s", messageID, userID)
+		return false, proto.NewUserError("not_found", fmt.Sprintf("message %s not found", messageID))
+	}
+	if h.messages[i].UserID != userID {
+		h.mutex.Unlock()
+		log.Printf("❌ Message %s not owned by %s, delete refused", messageID, userID)
+		return false, proto.NewUserError("forbidden", fmt.Sprintf("message %s is not yours to delete", messageID))
+	}
 
-	for i, msg := range h.messages {
-		if msg.ID == messageID && msg.UserID == userID {
-			log.Printf("🗑️ Deleting message %s by %s", messageID, userID)
-			h.messages = append(h.messages[:i], h.messages[i+1:]...)
-			return true
+	log.Printf("🗑️ Deleting message %s by %s", messageID, userID)
+	h.messages = append(h.messages[:i], h.messages[i+1:]...)
+	h.mutex.Unlock()
+
+	if h.wal != nil {
+		rec := walRecord{Op: walOpDelete, MessageID: messageID, UserID: userID}
+		if err := h.wal.append(rec); err != nil {
+			log.Printf("❌ WAL append error: %v", err)
 		}
 	}
-	log.Printf("❌ Message %s not found for deletion by %s", messageID, userID)
-	return false
+	return true, nil
 }
 
 func (c *Client) readPump(hub *Hub) {
+	var exitErr error
 	defer func() {
+		if exitErr != nil {
+			log.Printf("❌ Protocol error for %s: %v", c.Username, exitErr)
+			// writePump owns c.Conn's write side; route the error frame
+			// through c.Send instead of writing here directly, or we'd race
+			// it over the same connection.
+			select {
+			case c.Send <- outboundFrame{Payload: proto.ErrorMessage(c.ID, exitErr)}:
+			default:
+				log.Printf("❌ Send buffer full, dropping error frame for %s", c.Username)
+			}
+			_, closeFrame := proto.ErrorToWSCloseMessage(exitErr)
+			c.Conn.WriteControl(websocket.CloseMessage, closeFrame, time.Now().Add(5*time.Second))
+		}
 		log.Printf("🔌 Closing connection for %s", c.Username)
 		hub.unregister <- c
 		c.Conn.Close()
@@ -253,29 +496,46 @@ func (c *Client) readPump(hub *Hub) {
 
 		var incomingMsg map[string]interface{}
 		if err := json.Unmarshal(messageBytes, &incomingMsg); err != nil {
-			log.Printf("❌ JSON unmarshal error from %s: %v", c.Username, err)
-			continue
+			exitErr = proto.NewProtocolError("bad_json", fmt.Sprintf("invalid JSON: %v", err))
+			break
 		}
 
 		msgType, ok := incomingMsg["type"].(string)
 		if !ok {
-			log.Printf("❌ No message type from %s", c.Username)
-			continue
+			exitErr = proto.NewProtocolError("missing_type", "frame is missing a \"type\" field")
+			break
 		}
 
-		log.Printf("📥 Processing message type '%s' from %s", msgType, c.Username)
+		topic, _ := incomingMsg["topic"].(string)
+		if topic == "" {
+			topic = "general"
+		}
+
+		log.Printf("📥 Processing message type '%s' on topic '%s' from %s", msgType, topic, c.Username)
 
 		switch msgType {
+		case "subscribe":
+			since := c.DefaultSince
+			if sinceF, ok := incomingMsg["since"].(float64); ok {
+				sinceVal := uint64(sinceF)
+				since = &sinceVal
+			}
+			hub.subscribe <- subscription{client: c, topic: topic, since: since}
+
+		case "unsubscribe":
+			hub.unsubscribe <- subscription{client: c, topic: topic}
+
 		case "message":
 			content, ok := incomingMsg["content"].(string)
 			if !ok || content == "" {
-				log.Printf("❌ Invalid message content from %s", c.Username)
-				continue
+				exitErr = proto.NewProtocolError("bad_content", "\"message\" frame requires a non-empty string \"content\"")
+				break
 			}
 
 			message := Message{
 				ID:        uuid.New().String(),
 				Type:      "message",
+				Topic:     topic,
 				Username:  c.Username,
 				UserID:    c.ID,
 				Content:   content,
@@ -283,56 +543,69 @@ func (c *Client) readPump(hub *Hub) {
 				Edited:    false,
 			}
 
-			log.Printf("💬 New message from %s: %s", c.Username, content)
-			hub.addMessage(message)
+			log.Printf("💬 New message from %s on %s: %s", c.Username, topic, content)
+			message = hub.addMessage(message)
 			msgBytes, _ := json.Marshal(message)
-			hub.broadcast <- msgBytes
+			hub.broadcast <- topicMessage{topic: topic, payload: msgBytes}
 
 		case "typing":
 			isTyping, ok := incomingMsg["isTyping"].(bool)
 			if !ok {
-				log.Printf("❌ Invalid typing status from %s", c.Username)
-				continue
+				exitErr = proto.NewProtocolError("bad_typing", "\"typing\" frame requires a boolean \"isTyping\"")
+				break
 			}
-			log.Printf("⌨️ %s typing status: %v", c.Username, isTyping)
+			log.Printf("⌨️ %s typing status on %s: %v", c.Username, topic, isTyping)
 			c.IsTyping = isTyping
-			hub.broadcastUserList()
+			hub.broadcastUserList(topic)
 
 		case "edit":
 			messageID, ok1 := incomingMsg["messageId"].(string)
 			newContent, ok2 := incomingMsg["content"].(string)
 			if !ok1 || !ok2 {
-				log.Printf("❌ Invalid edit request from %s", c.Username)
-				continue
+				exitErr = proto.NewProtocolError("bad_edit", "\"edit\" frame requires string \"messageId\" and \"content\"")
+				break
 			}
 
-			if hub.editMessage(messageID, c.ID, newContent) {
+			if found, err := hub.editMessage(messageID, c.ID, newContent); err != nil {
+				exitErr = err
+			} else if found {
 				editMsg := map[string]interface{}{
 					"type":      "messageEdited",
+					"topic":     topic,
 					"messageId": messageID,
 					"content":   newContent,
 					"timestamp": time.Now(),
 				}
 				msgBytes, _ := json.Marshal(editMsg)
-				hub.broadcast <- msgBytes
+				hub.broadcast <- topicMessage{topic: topic, payload: msgBytes}
 			}
 
 		case "delete":
 			messageID, ok := incomingMsg["messageId"].(string)
 			if !ok {
-				log.Printf("❌ Invalid delete request from %s", c.Username)
-				continue
+				exitErr = proto.NewProtocolError("bad_delete", "\"delete\" frame requires a string \"messageId\"")
+				break
 			}
 
-			if hub.deleteMessage(messageID, c.ID) {
+			if found, err := hub.deleteMessage(messageID, c.ID); err != nil {
+				exitErr = err
+			} else if found {
 				deleteMsg := map[string]interface{}{
 					"type":      "messageDeleted",
+					"topic":     topic,
 					"messageId": messageID,
 					"timestamp": time.Now(),
 				}
 				msgBytes, _ := json.Marshal(deleteMsg)
-				hub.broadcast <- msgBytes
+				hub.broadcast <- topicMessage{topic: topic, payload: msgBytes}
 			}
+
+		default:
+			exitErr = proto.NewProtocolError("unknown_type", fmt.Sprintf("unknown message type %q", msgType))
+		}
+
+		if exitErr != nil {
+			break
 		}
 	}
 }
@@ -349,7 +622,7 @@ func (c *Client) writePump() {
 
 	for {
 		select {
-		case message, ok := <-c.Send:
+		case frame, ok := <-c.Send:
 			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
 			if !ok {
 				log.Printf("📤 Send channel closed for %s", c.Username)
@@ -357,7 +630,18 @@ func (c *Client) writePump() {
 				return
 			}
 
-			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			// Frames below MinCompressSize skip permessage-deflate: the
+			// per-frame CPU isn't worth it for small payloads like typing
+			// indicators. Binary frames (compressed history batches, see
+			// compressBatch) are already compressed, so deflating them
+			// again would just burn CPU for little to no size benefit.
+			c.Conn.EnableWriteCompression(!frame.Binary && len(frame.Payload) >= c.MinCompressSize)
+
+			messageType := websocket.TextMessage
+			if frame.Binary {
+				messageType = websocket.BinaryMessage
+			}
+			if err := c.Conn.WriteMessage(messageType, frame.Payload); err != nil {
 				log.Printf("❌ Write message error for %s: %v", c.Username, err)
 				return
 			}
@@ -380,21 +664,41 @@ func serveWS(hub *Hub, w http.ResponseWriter, r *http.Request) {
 		log.Printf("❌ WebSocket upgrade error: %v", err)
 		return
 	}
+	conn.EnableWriteCompression(true)
+	conn.SetCompressionLevel(*wsCompressionLevel)
 
 	username := r.URL.Query().Get("username")
 	if username == "" {
 		username = "Anonymous"
 	}
 
+	var defaultSince *uint64
+	if sinceParam := r.URL.Query().Get("since"); sinceParam != "" {
+		if sinceVal, err := strconv.ParseUint(sinceParam, 10, 64); err == nil {
+			defaultSince = &sinceVal
+		} else {
+			log.Printf("❌ Invalid since param %q: %v", sinceParam, err)
+		}
+	}
+
+	compressCodec := r.URL.Query().Get("compress")
+	if compressCodec != "" && !validCompressCodec(compressCodec) {
+		log.Printf("❌ Unsupported compress codec %q, ignoring", compressCodec)
+		compressCodec = ""
+	}
+
 	log.Printf("👤 Creating client for username: %s", username)
 
 	client := &Client{
-		ID:       uuid.New().String(),
-		Username: username,
-		Conn:     conn,
-		Send:     make(chan []byte, 256),
-		IsTyping: false,
-		LastSeen: time.Now(),
+		ID:              uuid.New().String(),
+		Username:        username,
+		Conn:            conn,
+		Send:            make(chan outboundFrame, 256),
+		IsTyping:        false,
+		LastSeen:        time.Now(),
+		DefaultSince:    defaultSince,
+		CompressCodec:   compressCodec,
+		MinCompressSize: hub.MinCompressSize,
 	}
 
 	hub.register <- client
@@ -403,14 +707,39 @@ func serveWS(hub *Hub, w http.ResponseWriter, r *http.Request) {
 	go client.readPump(hub)
 }
 
+const (
+	walPath            = "data/telechat.wal"
+	walMaxBytes        = 64 << 20 // 64MB
+	walMaxAge          = 7 * 24 * time.Hour
+	walFsyncPeriod     = 5 * time.Second
+	walRetentionPeriod = time.Minute
+)
+
 func main() {
+	flag.Parse()
+
 	hub := newHub()
+	if err := hub.loadWAL(walPath, walMaxBytes, walMaxAge); err != nil {
+		log.Fatalf("❌ Failed to open WAL at %s: %v", walPath, err)
+	}
+	go hub.wal.runFsyncLoop(walFsyncPeriod)
+	go hub.wal.runRetentionLoop(walRetentionPeriod)
 	go hub.run()
 
 	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
 		serveWS(hub, w, r)
 	})
 
+	http.HandleFunc("/pub/", func(w http.ResponseWriter, r *http.Request) {
+		handlePublish(hub, w, r)
+	})
+	http.HandleFunc("/sub/", func(w http.ResponseWriter, r *http.Request) {
+		handleSubscribeStream(hub, w, r)
+	})
+	http.HandleFunc("/messages/", func(w http.ResponseWriter, r *http.Request) {
+		handleMessagesHistory(hub, w, r)
+	})
+
 	http.Handle("/", http.FileServer(http.Dir("./static/")))
 
 	fmt.Println("🚀 Chat server starting on :9090")
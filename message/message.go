@@ -0,0 +1,19 @@
+// Package message holds the wire-protocol types shared between the
+// TeleChat server and its client packages, so both stay schema-aligned.
+package message
+
+import "time"
+
+// Message is a chat message as it appears over the wire: in broadcasts,
+// history replays, and the HTTP pub/sub endpoints.
+type Message struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Topic     string    `json:"topic"`
+	Seq       uint64    `json:"seq"`
+	Username  string    `json:"username"`
+	UserID    string    `json:"userId"`
+	Content   string    `json:"content"`
+	Timestamp time.Time `json:"timestamp"`
+	Edited    bool      `json:"edited"`
+}
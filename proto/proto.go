@@ -0,0 +1,104 @@
+// Package proto defines structured protocol-level errors for the WebSocket
+// layer and maps them onto WebSocket close codes and frames, modeled on the
+// galene protocolError/userError/errorToWSCloseMessage pattern.
+package proto
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolError means the client sent a frame the protocol doesn't allow:
+// malformed JSON, a missing or unknown "type", or a field of the wrong
+// shape. It closes the connection with ClosePolicyViolation.
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *ProtocolError) Error() string { return e.Message }
+
+// NewProtocolError builds a ProtocolError with the given machine-readable
+// code and human-readable message.
+func NewProtocolError(code, message string) error {
+	return &ProtocolError{Code: code, Message: message}
+}
+
+// UserError means the request was well-formed but not something this user
+// may do right now: the target message doesn't exist, or exists but is
+// owned by someone else. It closes the connection with CloseNormalClosure
+// since it's not a protocol violation, just a rejected action.
+type UserError struct {
+	Code    string
+	Message string
+}
+
+func (e *UserError) Error() string { return e.Message }
+
+// NewUserError builds a UserError with the given machine-readable code and
+// human-readable message.
+func NewUserError(code, message string) error {
+	return &UserError{Code: code, Message: message}
+}
+
+// InternalError means the server failed for reasons unrelated to client
+// input (e.g. the WAL couldn't be written). It closes the connection with
+// CloseInternalServerErr.
+type InternalError struct {
+	Code    string
+	Message string
+	Cause   error
+}
+
+func (e *InternalError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Cause)
+	}
+	return e.Message
+}
+
+func (e *InternalError) Unwrap() error { return e.Cause }
+
+// NewInternalError builds an InternalError wrapping cause.
+func NewInternalError(code, message string, cause error) error {
+	return &InternalError{Code: code, Message: message, Cause: cause}
+}
+
+// ErrorToWSCloseMessage maps err to the close text and a ready-to-send
+// CloseMessage control frame, picking the close code by error type.
+func ErrorToWSCloseMessage(err error) (text string, frame []byte) {
+	switch e := err.(type) {
+	case *ProtocolError:
+		return e.Message, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, e.Message)
+	case *UserError:
+		return e.Message, websocket.FormatCloseMessage(websocket.CloseNormalClosure, e.Message)
+	case *InternalError:
+		return e.Message, websocket.FormatCloseMessage(websocket.CloseInternalServerErr, e.Message)
+	default:
+		return err.Error(), websocket.FormatCloseMessage(websocket.CloseInternalServerErr, err.Error())
+	}
+}
+
+// ErrorMessage builds the {"type":"error",...} frame sent to the offending
+// client right before the close handshake so it gets an actionable code
+// instead of just a dropped connection.
+func ErrorMessage(userID string, err error) []byte {
+	code := "internal"
+	switch e := err.(type) {
+	case *ProtocolError:
+		code = e.Code
+	case *UserError:
+		code = e.Code
+	case *InternalError:
+		code = e.Code
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":    "error",
+		"userId":  userID,
+		"code":    code,
+		"message": err.Error(),
+	})
+	return payload
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// httpAuthSecret, when non-empty, must be presented in the X-TeleChat-Secret
+// header by every /pub, /sub, and /messages request.
+var httpAuthSecret = flag.String("http-auth-secret", "", "shared secret required in X-TeleChat-Secret for HTTP pub/sub endpoints (disabled if empty)")
+
+func checkHTTPAuth(w http.ResponseWriter, r *http.Request) bool {
+	if *httpAuthSecret == "" {
+		return true
+	}
+	if r.Header.Get("X-TeleChat-Secret") != *httpAuthSecret {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// chanSubscriber adapts a plain channel to the subscriber interface so HTTP
+// streaming consumers (GET /sub/{topic}) can sit in the same broadcast
+// fan-out as WebSocket *Client subscribers.
+type chanSubscriber struct {
+	ch chan []byte
+}
+
+func (s *chanSubscriber) Deliver(payload []byte) error {
+	select {
+	case s.ch <- payload:
+		return nil
+	default:
+		return fmt.Errorf("stream subscriber buffer full")
+	}
+}
+
+// subscribeRaw registers sub to topic outside the WebSocket
+// subscribe/unsubscribe message flow. The returned func must be called once
+// the subscriber goes away to remove it from the topic.
+func (h *Hub) subscribeRaw(topic string, sub subscriber) func() {
+	h.mutex.Lock()
+	h.getOrCreateTopic(topic)
+	if h.subscribers[topic] == nil {
+		h.subscribers[topic] = make(map[subscriber]bool)
+	}
+	h.subscribers[topic][sub] = true
+	h.mutex.Unlock()
+
+	return func() {
+		h.mutex.Lock()
+		if subs, ok := h.subscribers[topic]; ok {
+			delete(subs, sub)
+		}
+		h.mutex.Unlock()
+	}
+}
+
+// messagesSince returns messages on topic with Seq > since, oldest first,
+// capped at limit.
+func (h *Hub) messagesSince(topic string, since uint64, limit int) []Message {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	result := make([]Message, 0, limit)
+	for _, msg := range h.messages {
+		if msg.Topic != topic || msg.Seq <= since {
+			continue
+		}
+		result = append(result, msg)
+		if len(result) >= limit {
+			break
+		}
+	}
+	return result
+}
+
+// handlePublish implements POST /pub/{topic}: publish a message without a
+// WebSocket connection, the ntfy-style HTTP publish pattern. The body is
+// used as the message content unless it's a JSON object carrying its own
+// "content"/"username" fields.
+func handlePublish(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkHTTPAuth(w, r) {
+		return
+	}
+	topic := strings.TrimPrefix(r.URL.Path, "/pub/")
+	if topic == "" {
+		http.Error(w, "missing topic", http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	content := strings.TrimSpace(string(body))
+	username := "http"
+	if ct := r.Header.Get("Content-Type"); strings.Contains(ct, "application/json") {
+		var payload struct {
+			Content  string `json:"content"`
+			Username string `json:"username"`
+		}
+		if err := json.Unmarshal(body, &payload); err == nil && payload.Content != "" {
+			content = payload.Content
+			if payload.Username != "" {
+				username = payload.Username
+			}
+		}
+	}
+	if content == "" {
+		http.Error(w, "empty message", http.StatusBadRequest)
+		return
+	}
+
+	message := Message{
+		ID:        uuid.New().String(),
+		Type:      "message",
+		Topic:     topic,
+		Username:  username,
+		UserID:    "http:" + username,
+		Content:   content,
+		Timestamp: time.Now(),
+	}
+	message = hub.addMessage(message)
+	msgBytes, _ := json.Marshal(message)
+	hub.broadcast <- topicMessage{topic: topic, payload: msgBytes}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(message)
+}
+
+// handleSubscribeStream implements GET /sub/{topic}: a chunked,
+// newline-delimited JSON stream of every message published to topic from
+// here on, until the client disconnects.
+func handleSubscribeStream(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkHTTPAuth(w, r) {
+		return
+	}
+	topic := strings.TrimPrefix(r.URL.Path, "/sub/")
+	if topic == "" {
+		http.Error(w, "missing topic", http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := &chanSubscriber{ch: make(chan []byte, 64)}
+	unsubscribe := hub.subscribeRaw(topic, sub)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case payload := <-sub.ch:
+			if _, err := w.Write(append(payload, '\n')); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleMessagesHistory implements GET /messages/{topic}?since=<seq>&limit=N:
+// a point-in-time JSON array of historical messages, for consumers that just
+// want a snapshot rather than a live stream.
+func handleMessagesHistory(hub *Hub, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !checkHTTPAuth(w, r) {
+		return
+	}
+	topic := strings.TrimPrefix(r.URL.Path, "/messages/")
+	if topic == "" {
+		http.Error(w, "missing topic", http.StatusBadRequest)
+		return
+	}
+
+	var since uint64
+	if s := r.URL.Query().Get("since"); s != "" {
+		if v, err := strconv.ParseUint(s, 10, 64); err == nil {
+			since = v
+		}
+	}
+	limit := 50
+	if l := r.URL.Query().Get("limit"); l != "" {
+		if v, err := strconv.Atoi(l); err == nil && v > 0 {
+			limit = v
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hub.messagesSince(topic, since, limit))
+}
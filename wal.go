@@ -0,0 +1,286 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// walOp identifies which Hub mutation a WAL record represents.
+type walOp string
+
+const (
+	walOpAdd    walOp = "add"
+	walOpEdit   walOp = "edit"
+	walOpDelete walOp = "delete"
+)
+
+// walRecord is the length-prefixed unit appended to the log for every
+// mutation so the message history can be replayed on startup.
+type walRecord struct {
+	Op        walOp   `json:"op"`
+	Message   Message `json:"message,omitempty"`
+	MessageID string  `json:"messageId,omitempty"`
+	UserID    string  `json:"userId,omitempty"`
+	Content   string  `json:"content,omitempty"`
+}
+
+// WAL is a simple append-only, length-prefixed write-ahead log backing
+// Hub.messages. Frames are [4-byte big-endian length][JSON payload].
+// Retention is enforced by size and age; once either is exceeded the log is
+// compacted by rewriting it with only the records still inside the window.
+type WAL struct {
+	mutex     sync.Mutex
+	file      *os.File
+	path      string
+	size      int64
+	maxBytes  int64
+	maxAge    time.Duration
+	oldestAdd time.Time // timestamp of the oldest surviving walOpAdd record, zero if unknown
+}
+
+// openWAL opens (or creates) the log at path. maxBytes <= 0 or
+// maxAge <= 0 disable that half of the retention policy.
+func openWAL(path string, maxBytes int64, maxAge time.Duration) (*WAL, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &WAL{
+		file:     f,
+		path:     path,
+		size:     info.Size(),
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+	}, nil
+}
+
+// replay reads every record from the start of the log and invokes fn for
+// each, in the order they were written.
+func (w *WAL) replay(fn func(walRecord)) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	lenBuf := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(w.file, lenBuf); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		size := binary.BigEndian.Uint32(lenBuf)
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(w.file, payload); err != nil {
+			break // truncated trailing record, stop replay
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			log.Printf("❌ Skipping corrupt WAL record: %v", err)
+			continue
+		}
+		if rec.Op == walOpAdd && w.oldestAdd.IsZero() {
+			w.oldestAdd = rec.Message.Timestamp
+		}
+		fn(rec)
+	}
+
+	// Position the file back at the end for subsequent appends.
+	end, err := w.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	w.size = end
+	return nil
+}
+
+// append writes rec to the end of the log and fsyncs it before returning, so
+// a crash right after append never loses an acknowledged mutation.
+func (w *WAL) append(rec walRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	lenBuf := make([]byte, 4)
+	binary.BigEndian.PutUint32(lenBuf, uint32(len(payload)))
+
+	if _, err := w.file.Write(lenBuf); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(payload); err != nil {
+		return err
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.size += int64(len(lenBuf) + len(payload))
+	if rec.Op == walOpAdd && w.oldestAdd.IsZero() {
+		w.oldestAdd = rec.Message.Timestamp
+	}
+	return nil
+}
+
+// runFsyncLoop periodically syncs the log to disk as a backstop; append
+// already fsyncs per-record, so this mainly guards future batching changes.
+func (w *WAL) runFsyncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		w.mutex.Lock()
+		if err := w.file.Sync(); err != nil {
+			log.Printf("❌ WAL fsync error: %v", err)
+		}
+		w.mutex.Unlock()
+	}
+}
+
+// runRetentionLoop periodically checks whether the log has grown past
+// maxBytes or its oldest record past maxAge, and compacts it when it has.
+func (w *WAL) runRetentionLoop(interval time.Duration) {
+	if w.maxBytes <= 0 && w.maxAge <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-w.maxAge)
+		err := w.compact(func(rec walRecord) bool {
+			if w.maxAge <= 0 {
+				return true
+			}
+			return rec.Op != walOpAdd || rec.Message.Timestamp.After(cutoff)
+		})
+		if err != nil {
+			log.Printf("❌ WAL retention compaction error: %v", err)
+		}
+	}
+}
+
+// compact rewrites the log keeping only records that pass keep, enforcing
+// both the byte and age retention limits in one pass. It's a no-op unless
+// the log is actually over one of those limits.
+func (w *WAL) compact(keep func(walRecord) bool) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	sizeExceeded := w.maxBytes > 0 && w.size > w.maxBytes
+	ageExceeded := w.maxAge > 0 && !w.oldestAdd.IsZero() && time.Since(w.oldestAdd) > w.maxAge
+	if !sizeExceeded && !ageExceeded {
+		return nil
+	}
+
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	type entry struct {
+		lenBuf  []byte
+		payload []byte
+		rec     walRecord
+	}
+	var entries []entry
+	for {
+		lenBuf := make([]byte, 4)
+		if _, err := io.ReadFull(w.file, lenBuf); err != nil {
+			break
+		}
+		size := binary.BigEndian.Uint32(lenBuf)
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(w.file, payload); err != nil {
+			break
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(payload, &rec); err != nil {
+			continue
+		}
+		if !keep(rec) {
+			continue
+		}
+		entries = append(entries, entry{lenBuf, payload, rec})
+	}
+
+	// The age filter above may still leave the log over maxBytes (e.g. a
+	// burst of recent records). Enforce the byte cap by dropping the
+	// oldest surviving entries until the total fits.
+	if w.maxBytes > 0 {
+		var total int64
+		for _, e := range entries {
+			total += int64(len(e.lenBuf) + len(e.payload))
+		}
+		cut := 0
+		for total > w.maxBytes && cut < len(entries) {
+			total -= int64(len(entries[cut].lenBuf) + len(entries[cut].payload))
+			cut++
+		}
+		entries = entries[cut:]
+	}
+
+	tmpPath := w.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+
+	var kept int64
+	var oldestAdd time.Time
+	for _, e := range entries {
+		if _, err := tmp.Write(e.lenBuf); err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := tmp.Write(e.payload); err != nil {
+			tmp.Close()
+			return err
+		}
+		kept += int64(len(e.lenBuf) + len(e.payload))
+		if e.rec.Op == walOpAdd && oldestAdd.IsZero() {
+			oldestAdd = e.rec.Message.Timestamp
+		}
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	w.file.Close()
+	if err := os.Rename(tmpPath, w.path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = kept
+	w.oldestAdd = oldestAdd
+	log.Printf("🧹 WAL compacted to %d bytes at %s", kept, w.path)
+	return nil
+}